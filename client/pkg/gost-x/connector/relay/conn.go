@@ -9,6 +9,7 @@ import (
 	"math"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/go-gost/core/common/bufpool"
 	mdata "github.com/go-gost/core/metadata"
@@ -16,17 +17,137 @@ import (
 	xrelay "github.com/go-gost/x/internal/util/relay"
 )
 
+// deadlineTimer manages a read and a write deadline independently, each
+// backed by a *time.Timer and a cancel channel. It mirrors the adapter
+// netstack's gonet package uses to make an otherwise deadline-less
+// connection interruptible: Read/Write select on the relevant channel
+// and bail out with a timeout net.Error once the deadline fires.
+type deadlineTimer struct {
+	mu sync.Mutex
+
+	readTimer  *time.Timer
+	readCancel chan struct{}
+
+	writeTimer  *time.Timer
+	writeCancel chan struct{}
+}
+
+// errDeadlineExceeded is returned by Read/Write when a deadline fires
+// before the underlying I/O completes.
+type errDeadlineExceeded struct{}
+
+func (errDeadlineExceeded) Error() string   { return os_ErrDeadlineExceeded }
+func (errDeadlineExceeded) Timeout() bool   { return true }
+func (errDeadlineExceeded) Temporary() bool { return true }
+
+const os_ErrDeadlineExceeded = "i/o timeout"
+
+func (d *deadlineTimer) setDeadline(deadline time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.setReadDeadlineLocked(deadline)
+	d.setWriteDeadlineLocked(deadline)
+}
+
+func (d *deadlineTimer) setReadDeadline(deadline time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.setReadDeadlineLocked(deadline)
+}
+
+func (d *deadlineTimer) setWriteDeadline(deadline time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.setWriteDeadlineLocked(deadline)
+}
+
+// setReadDeadlineLocked and setWriteDeadlineLocked always mint a fresh
+// cancel channel rather than inferring from Timer.Stop()'s return value
+// whether the previous timer's callback already closed the old one.
+// Stop() returning false only means the callback was scheduled (or is
+// mid-run), not that close(cancel) has happened yet; reusing the old
+// channel in that window would race the callback, which could then
+// close the *new* deadline's channel moments later (a spurious timeout
+// on a conn that should still be healthy) or close an already-closed
+// channel and panic. Each AfterFunc callback instead re-checks, under
+// d.mu, whether it is still the active timer for its direction before
+// closing anything, so a stale callback from a superseded deadline is
+// always a safe no-op.
+func (d *deadlineTimer) setReadDeadlineLocked(deadline time.Time) {
+	if d.readTimer != nil {
+		d.readTimer.Stop()
+	}
+	d.readCancel = make(chan struct{})
+
+	if deadline.IsZero() {
+		d.readTimer = nil
+		return
+	}
+
+	cancel := d.readCancel
+	d.readTimer = time.AfterFunc(time.Until(deadline), func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.readCancel != cancel {
+			return
+		}
+		close(cancel)
+	})
+}
+
+func (d *deadlineTimer) setWriteDeadlineLocked(deadline time.Time) {
+	if d.writeTimer != nil {
+		d.writeTimer.Stop()
+	}
+	d.writeCancel = make(chan struct{})
+
+	if deadline.IsZero() {
+		d.writeTimer = nil
+		return
+	}
+
+	cancel := d.writeCancel
+	d.writeTimer = time.AfterFunc(time.Until(deadline), func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.writeCancel != cancel {
+			return
+		}
+		close(cancel)
+	})
+}
+
+func (d *deadlineTimer) readChan() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.readCancel == nil {
+		d.readCancel = make(chan struct{})
+	}
+	return d.readCancel
+}
+
+func (d *deadlineTimer) writeChan() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.writeCancel == nil {
+		d.writeCancel = make(chan struct{})
+	}
+	return d.writeCancel
+}
+
 type tcpConn struct {
 	net.Conn
 	wbuf *bytes.Buffer
 	once sync.Once
 	mu   sync.Mutex
+
+	dt deadlineTimer
 }
 
 func (c *tcpConn) Read(b []byte) (n int, err error) {
 	c.once.Do(func() {
 		if c.wbuf != nil {
-			err = readResponse(c.Conn)
+			err = readResponseDeadline(c.Conn, c.dt.readChan())
 		}
 	})
 
@@ -42,54 +163,68 @@ func (c *tcpConn) Write(b []byte) (n int, err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	w := deadlineWriter{conn: c.Conn, cancel: c.dt.writeChan()}
+
 	if c.wbuf != nil && c.wbuf.Len() > 0 {
 		c.wbuf.Write(b) // append the data to the cached header
-		_, err = c.Conn.Write(c.wbuf.Bytes())
+		_, err = w.Write(c.wbuf.Bytes())
 		c.wbuf.Reset()
 		return
 	}
-	_, err = c.Conn.Write(b)
+	_, err = w.Write(b)
 	return
 }
 
+func (c *tcpConn) SetDeadline(t time.Time) error {
+	c.dt.setDeadline(t)
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *tcpConn) SetReadDeadline(t time.Time) error {
+	c.dt.setReadDeadline(t)
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *tcpConn) SetWriteDeadline(t time.Time) error {
+	c.dt.setWriteDeadline(t)
+	return c.Conn.SetWriteDeadline(t)
+}
+
 type udpConn struct {
 	net.Conn
 	wbuf *bytes.Buffer
 	once sync.Once
 	mu   sync.Mutex
+	// md negotiates the chunked framing extension (see udpFramer); nil
+	// means the peer only speaks the legacy single-frame format.
+	md mdata.Metadata
+
+	dt deadlineTimer
+	fr udpFramer
+}
+
+func (c *udpConn) framer() *udpFramer {
+	c.fr.md = c.md
+	return &c.fr
 }
 
 func (c *udpConn) Read(b []byte) (n int, err error) {
 	c.once.Do(func() {
 		if c.wbuf != nil {
-			err = readResponse(c.Conn)
+			err = readResponseDeadline(c.Conn, c.dt.readChan())
 		}
 	})
 	if err != nil {
 		return
 	}
 
-	var bb [2]byte
-	_, err = io.ReadFull(c.Conn, bb[:])
-	if err != nil {
-		return
-	}
-
-	dlen := int(binary.BigEndian.Uint16(bb[:]))
-	if len(b) >= dlen {
-		return io.ReadFull(c.Conn, b[:dlen])
-	}
-
-	buf := bufpool.Get(dlen)
-	defer bufpool.Put(buf)
-	_, err = io.ReadFull(c.Conn, buf)
-	n = copy(b, buf)
-
-	return
+	return c.framer().readChunked(c.Conn, b, c.dt.readChan())
 }
 
 func (c *udpConn) Write(b []byte) (n int, err error) {
-	if len(b) > math.MaxUint16 {
+	fr := c.framer()
+
+	if !fr.chunked() && len(b) > math.MaxUint16 {
 		err = errors.New("write: data maximum exceeded")
 		return
 	}
@@ -99,22 +234,155 @@ func (c *udpConn) Write(b []byte) (n int, err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if !fr.chunked() {
+		err = c.writeFrame(b)
+		return
+	}
+	err = fr.writeChunked(c.writeFrame, b)
+	return
+}
+
+// writeFrame writes one legacy-framed segment to the wire, prepending
+// the cached handshake header ahead of the very first outgoing frame.
+// In chunked mode it is called once per fragment by udpFramer.writeChunked.
+func (c *udpConn) writeFrame(b []byte) (err error) {
+	if len(b) > math.MaxUint16 {
+		return errors.New("write: data maximum exceeded")
+	}
+
+	w := deadlineWriter{conn: c.Conn, cancel: c.dt.writeChan()}
+
 	if c.wbuf != nil && c.wbuf.Len() > 0 {
 		var bb [2]byte
 		binary.BigEndian.PutUint16(bb[:], uint16(len(b)))
 		c.wbuf.Write(bb[:])
 		c.wbuf.Write(b) // append the data to the cached header
-		_, err = c.wbuf.WriteTo(c.Conn)
+		_, err = c.wbuf.WriteTo(w)
 		return
 	}
 
 	var bb [2]byte
 	binary.BigEndian.PutUint16(bb[:], uint16(len(b)))
-	_, err = c.Conn.Write(bb[:])
-	if err != nil {
+	if _, err = w.Write(bb[:]); err != nil {
 		return
 	}
-	return c.Conn.Write(b)
+	_, err = w.Write(b)
+	return
+}
+
+func (c *udpConn) SetDeadline(t time.Time) error {
+	c.dt.setDeadline(t)
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *udpConn) SetReadDeadline(t time.Time) error {
+	c.dt.setReadDeadline(t)
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *udpConn) SetWriteDeadline(t time.Time) error {
+	c.dt.setWriteDeadline(t)
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// readFullDeadline behaves like io.ReadFull but also returns
+// errDeadlineExceeded if cancel is closed before the read completes. On
+// cancel it closes conn and waits for the abandoned read to return
+// before handing buf back to the caller: buf is typically a pooled
+// buffer the caller frees on return, and io.ReadFull(conn, buf) would
+// otherwise keep writing into it (or leak forever on a silent peer)
+// after readFullDeadline has already returned.
+//
+// Closing conn here is deliberate and, unlike readResponseDeadline and
+// writeDeadline below, scoped to this function rather than applied to
+// every deadline-guarded op: readFullDeadline is only ever used to read
+// one piece of a length-prefixed frame (chunk.go's readChunked /
+// readPayload), so a timeout here always means a frame was read
+// partway through and the conn's framing can no longer be trusted for
+// any subsequent read — there is no safe way to "skip" the unread
+// remainder. That is a narrower, conn-specific failure than an ordinary
+// read/write timeout, which should leave the conn usable (see
+// readResponseDeadline).
+func readFullDeadline(conn net.Conn, buf []byte, cancel chan struct{}) (int, error) {
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := io.ReadFull(conn, buf)
+		done <- result{n, err}
+	}()
+
+	select {
+	case <-cancel:
+		conn.Close()
+		<-done
+		return 0, errDeadlineExceeded{}
+	case res := <-done:
+		return res.n, res.err
+	}
+}
+
+// readResponseDeadline behaves like readResponse but also returns
+// errDeadlineExceeded if cancel is closed before the read completes.
+// Unlike readFullDeadline, it does not close conn on cancel: readResponse
+// only ever populates a relay.Response local to the abandoned goroutine,
+// never a buffer the caller frees back to a pool, so there is nothing
+// for that goroutine to race against. Leaving conn open lets a caller
+// that uses a short deadline as a liveness probe extend the deadline and
+// keep using the connection, per the normal net.Conn contract, instead
+// of having the very first timeout destroy it.
+func readResponseDeadline(conn net.Conn, cancel chan struct{}) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- readResponse(conn)
+	}()
+
+	select {
+	case <-cancel:
+		return errDeadlineExceeded{}
+	case err := <-done:
+		return err
+	}
+}
+
+// writeDeadline behaves like conn.Write but also returns
+// errDeadlineExceeded if cancel is closed before the write completes. It
+// does not close conn on cancel — see readResponseDeadline — so an
+// ordinary write timeout doesn't poison the connection the way a
+// half-read UDP frame does; see readFullDeadline. Callers that write
+// from a pooled buffer must not return it to the pool on a cancelled
+// write, since the abandoned goroutine above may still be reading it.
+func writeDeadline(conn net.Conn, buf []byte, cancel chan struct{}) (int, error) {
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := conn.Write(buf)
+		done <- result{n, err}
+	}()
+
+	select {
+	case <-cancel:
+		return 0, errDeadlineExceeded{}
+	case res := <-done:
+		return res.n, res.err
+	}
+}
+
+// deadlineWriter adapts writeDeadline to an io.Writer so existing
+// wbuf-prepend call sites (bytes.Buffer.WriteTo, plain Write calls) stay
+// cancellable without threading a cancel channel through each of them.
+type deadlineWriter struct {
+	conn   net.Conn
+	cancel chan struct{}
+}
+
+func (w deadlineWriter) Write(p []byte) (int, error) {
+	return writeDeadline(w.conn, p, w.cancel)
 }
 
 func readResponse(r io.Reader) (err error) {
@@ -141,6 +409,8 @@ type bindConn struct {
 	localAddr  net.Addr
 	remoteAddr net.Addr
 	md         mdata.Metadata
+
+	dt deadlineTimer
 }
 
 func (c *bindConn) LocalAddr() net.Addr {
@@ -156,49 +426,104 @@ func (c *bindConn) Metadata() mdata.Metadata {
 	return c.md
 }
 
+func (c *bindConn) SetDeadline(t time.Time) error {
+	c.dt.setDeadline(t)
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *bindConn) SetReadDeadline(t time.Time) error {
+	c.dt.setReadDeadline(t)
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *bindConn) SetWriteDeadline(t time.Time) error {
+	c.dt.setWriteDeadline(t)
+	return c.Conn.SetWriteDeadline(t)
+}
+
 type bindUDPConn struct {
 	net.Conn
 	localAddr  net.Addr
 	remoteAddr net.Addr
 	md         mdata.Metadata
+	mu         sync.Mutex
+
+	dt deadlineTimer
+	fr udpFramer
+}
+
+func (c *bindUDPConn) framer() *udpFramer {
+	c.fr.md = c.md
+	return &c.fr
 }
 
 func (c *bindUDPConn) Read(b []byte) (n int, err error) {
-	// 2-byte data length header
-	var bh [2]byte
-	_, err = io.ReadFull(c.Conn, bh[:])
-	if err != nil {
-		return
-	}
+	return c.framer().readChunked(c.Conn, b, c.dt.readChan())
+}
 
-	dlen := int(binary.BigEndian.Uint16(bh[:]))
-	if len(b) >= dlen {
-		n, err = io.ReadFull(c.Conn, b[:dlen])
+// Write serializes its caller the same way udpConn.Write does: when
+// chunking is negotiated, writeChunked issues one Conn.Write per
+// fragment, and udpFramer.writeChunked's own contract requires the
+// caller to hold whatever lock keeps those from interleaving with
+// another Write's fragments on the wire.
+func (c *bindUDPConn) Write(b []byte) (n int, err error) {
+	fr := c.framer()
+
+	if !fr.chunked() && len(b) > math.MaxUint16 {
+		err = errors.New("write: data maximum exceeded")
 		return
 	}
 
-	buf := bufpool.Get(dlen)
-	defer bufpool.Put(buf)
+	n = len(b)
 
-	_, err = io.ReadFull(c.Conn, buf)
-	n = copy(b, buf)
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
+	if !fr.chunked() {
+		err = c.writeFrame(b)
+		return
+	}
+	err = fr.writeChunked(c.writeFrame, b)
 	return
 }
 
-func (c *bindUDPConn) Write(b []byte) (n int, err error) {
+// writeFrame writes one legacy-framed segment to the wire. In chunked
+// mode it is called once per fragment by udpFramer.writeChunked.
+func (c *bindUDPConn) writeFrame(b []byte) (err error) {
 	if len(b) > math.MaxUint16 {
-		err = errors.New("write: data maximum exceeded")
-		return
+		return errors.New("write: data maximum exceeded")
 	}
 
 	buf := bufpool.Get(len(b) + 2)
-	defer bufpool.Put(buf)
 
 	binary.BigEndian.PutUint16(buf[:2], uint16(len(b)))
-	n = copy(buf[2:], b)
+	copy(buf[2:], b)
+
+	_, err = writeDeadline(c.Conn, buf, c.dt.writeChan())
+	// writeDeadline no longer waits for an abandoned write on cancel (see
+	// its doc comment), so buf may still be in use; only the pool on a
+	// completed write, never on a timeout, to avoid handing the same
+	// backing array to a concurrent Get while that write is still
+	// reading from it.
+	if err != (errDeadlineExceeded{}) {
+		bufpool.Put(buf)
+	}
+	return
+}
+
+func (c *bindUDPConn) SetDeadline(t time.Time) error {
+	c.dt.setDeadline(t)
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *bindUDPConn) SetReadDeadline(t time.Time) error {
+	c.dt.setReadDeadline(t)
+	return c.Conn.SetReadDeadline(t)
+}
 
-	return c.Conn.Write(buf)
+func (c *bindUDPConn) SetWriteDeadline(t time.Time) error {
+	c.dt.setWriteDeadline(t)
+	return c.Conn.SetWriteDeadline(t)
 }
 
 func (c *bindUDPConn) ReadFrom(b []byte) (n int, addr net.Addr, err error) {
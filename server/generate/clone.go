@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"server/model"
+)
+
+// cloneModels lists every model type that gets a generated Clone()
+// method. Keep this in sync with the ApplyBasic list in main.go: anything
+// the gorm/gen pass queries is a candidate for aliasing the session's
+// result, so it should be cloneable.
+var cloneModels = []any{
+	model.GostAuth{},
+	model.GostClient{},
+	model.GostClientAdmission{},
+	model.GostClientConfig{},
+	model.GostClientForward{},
+	model.GostClientHost{},
+	model.GostClientHostDomain{},
+	model.GostClientTunnel{},
+	model.GostClientProxy{},
+	model.GostClientP2P{},
+	model.GostNode{},
+	model.GostNodeBind{},
+	model.GostNodeConfig{},
+	model.GostNodeDomain{},
+	model.GostNodePort{},
+	model.GostObs{},
+	model.SystemConfig{},
+	model.SystemNotice{},
+	model.SystemUser{},
+	model.SystemUserCheckin{},
+	model.SystemUserEmail{},
+	model.FrpClientCfg{},
+}
+
+// atomicFieldTypes are copied by a plain value assignment and never
+// walked field-by-field: they already have value semantics (time.Time,
+// decimal.Decimal) so a deep walk would just reproduce the same bits.
+var atomicFieldTypes = map[string]bool{
+	"time.Time":       true,
+	"decimal.Decimal": true,
+}
+
+// modelPkgPath is the import path of the package this generator writes
+// into; types from it need no import line in the generated file.
+const modelPkgPath = "server/model"
+
+// maxCloneDepth bounds how deep emitFieldCopy/emitStructClone recurse
+// into nested struct/ptr/slice/map fields. Model structs aren't
+// expected to self-reference, but this keeps a pathological or
+// accidentally cyclic field shape from hanging the generator instead of
+// silently producing an infinite recursion; fields beyond the bound
+// fall back to a shallow copy.
+const maxCloneDepth = 8
+
+// genClone emits outDir/zz_clone.go containing a Clone() *T method for
+// every type in cloneModels plus a package-level Clone(dst, src any)
+// dispatcher, the same shape as tailscale's cmd/cloner. It walks each
+// struct's fields via reflect, recursively deep-copying pointer, slice
+// and map fields (including ones nested inside other structs) and
+// skipping unexported fields, so cache writes and API layers can detach
+// a gorm query result instead of mutating (and aliasing) it in place.
+func genClone(outDir string) error {
+	var body bytes.Buffer
+	imports := map[string]string{}
+
+	names := make([]string, 0, len(cloneModels))
+	for _, v := range cloneModels {
+		t := reflect.TypeOf(v)
+		names = append(names, t.Name())
+		if err := writeCloneMethod(&body, t, imports); err != nil {
+			return fmt.Errorf("generate Clone for %s: %w", t.Name(), err)
+		}
+	}
+	writeCloneDispatcher(&body, names)
+
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by server/generate. DO NOT EDIT.\n\n")
+	buf.WriteString("package model\n\n")
+	writeImports(&buf, imports)
+	buf.Write(body.Bytes())
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("format generated clone code: %w", err)
+	}
+	return os.WriteFile(filepath.Join(outDir, "zz_clone.go"), src, 0o644)
+}
+
+func writeImports(buf *bytes.Buffer, imports map[string]string) {
+	if len(imports) == 0 {
+		return
+	}
+	paths := make([]string, 0, len(imports))
+	for p := range imports {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	buf.WriteString("import (\n")
+	for _, p := range paths {
+		fmt.Fprintf(buf, "\t%q\n", p)
+	}
+	buf.WriteString(")\n\n")
+}
+
+func writeCloneMethod(buf *bytes.Buffer, t reflect.Type, imports map[string]string) error {
+	if t.Kind() != reflect.Struct {
+		return fmt.Errorf("%s is not a struct", t.Name())
+	}
+
+	fmt.Fprintf(buf, "// Clone returns a deep copy of src, detached from any gorm session.\n")
+	fmt.Fprintf(buf, "func (src *%s) Clone() *%s {\n", t.Name(), t.Name())
+	buf.WriteString("\tif src == nil {\n\t\treturn nil\n\t}\n")
+	fmt.Fprintf(buf, "\tdst := new(%s)\n", t.Name())
+	emitStructClone(buf, "*dst", "*src", t, imports, 1)
+	buf.WriteString("\treturn dst\n}\n\n")
+	return nil
+}
+
+// emitStructClone emits "<dstLValue> = <srcExpr>" (a shallow copy of
+// every field) followed by statements patching every field that needs
+// more than a value copy, so nothing in dstLValue is left aliasing
+// srcExpr.
+func emitStructClone(buf *bytes.Buffer, dstLValue, srcExpr string, t reflect.Type, imports map[string]string, depth int) {
+	fmt.Fprintf(buf, "\t%s = %s\n", dstLValue, srcExpr)
+	if depth > maxCloneDepth {
+		return
+	}
+
+	// Field access never needs the leading "*": Go lets a selector on a
+	// pointer (dst.Field) auto-dereference, so strip it here rather than
+	// emit the invalid "*dst.Field" (which parses as *(dst.Field)).
+	dstBase := strings.TrimPrefix(dstLValue, "*")
+	srcBase := strings.TrimPrefix(srcExpr, "*")
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+		if !needsDeepCopy(f.Type) {
+			continue
+		}
+		emitFieldCopy(buf, dstBase+"."+f.Name, srcBase+"."+f.Name, f.Type, imports, depth+1)
+	}
+}
+
+// needsDeepCopy reports whether a field of type t can be left to the
+// shallow "dst = src" struct copy, or needs emitFieldCopy to patch it.
+func needsDeepCopy(t reflect.Type) bool {
+	if atomicFieldTypes[t.String()] {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map:
+		return true
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath == "" && needsDeepCopy(f.Type) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// emitFieldCopy emits statements overwriting dstSel (already
+// shallow-copied from srcSel by the enclosing emitStructClone) with a
+// deep copy, recursing into nested struct/ptr/slice/map fields so a
+// pointer, slice or map anywhere in the type is never left aliasing the
+// source.
+func emitFieldCopy(buf *bytes.Buffer, dstSel, srcSel string, t reflect.Type, imports map[string]string, depth int) {
+	if depth > maxCloneDepth {
+		// give up recursing any further and leave the shallow copy in
+		// place rather than risk an unbounded generator loop.
+		return
+	}
+
+	// Every temporary this call introduces (the new pointee, the loop
+	// index/key/value, the map-element working copy) is suffixed with
+	// depth, which strictly increases on every recursive call below.
+	// That guarantees a temp introduced here can never be shadowed by one
+	// a deeper recursive call introduces for the same purpose — e.g. a
+	// map[string]*T value's "new(T)" no longer collides with an
+	// enclosing "for k, v := range" binding named the same thing.
+	switch t.Kind() {
+	case reflect.Ptr:
+		elem := t.Elem()
+		p := fmt.Sprintf("p%d", depth)
+		fmt.Fprintf(buf, "\tif %s != nil {\n", srcSel)
+		fmt.Fprintf(buf, "\t\t%s := new(%s)\n", p, typeName(imports, elem))
+		if elem.Kind() == reflect.Struct && needsDeepCopy(elem) {
+			emitStructClone(buf, "*"+p, "*"+srcSel, elem, imports, depth+1)
+		} else {
+			fmt.Fprintf(buf, "\t\t*%s = *%s\n", p, srcSel)
+		}
+		fmt.Fprintf(buf, "\t\t%s = %s\n", dstSel, p)
+		buf.WriteString("\t}\n")
+
+	case reflect.Slice:
+		elem := t.Elem()
+		i := fmt.Sprintf("i%d", depth)
+		fmt.Fprintf(buf, "\tif %s != nil {\n", srcSel)
+		fmt.Fprintf(buf, "\t\t%s = make(%s, len(%s))\n", dstSel, typeName(imports, t), srcSel)
+		if elem.Kind() == reflect.Struct && needsDeepCopy(elem) {
+			fmt.Fprintf(buf, "\t\tfor %s := range %s {\n", i, srcSel)
+			emitStructClone(buf, dstSel+"["+i+"]", srcSel+"["+i+"]", elem, imports, depth+1)
+			buf.WriteString("\t\t}\n")
+		} else if needsDeepCopy(elem) {
+			fmt.Fprintf(buf, "\t\tfor %s := range %s {\n", i, srcSel)
+			emitFieldCopy(buf, dstSel+"["+i+"]", srcSel+"["+i+"]", elem, imports, depth+1)
+			buf.WriteString("\t\t}\n")
+		} else {
+			fmt.Fprintf(buf, "\t\tcopy(%s, %s)\n", dstSel, srcSel)
+		}
+		buf.WriteString("\t}\n")
+
+	case reflect.Map:
+		elem := t.Elem()
+		k := fmt.Sprintf("k%d", depth)
+		v := fmt.Sprintf("v%d", depth)
+		e := fmt.Sprintf("e%d", depth)
+		fmt.Fprintf(buf, "\tif %s != nil {\n", srcSel)
+		fmt.Fprintf(buf, "\t\t%s = make(%s, len(%s))\n", dstSel, typeName(imports, t), srcSel)
+		fmt.Fprintf(buf, "\t\tfor %s, %s := range %s {\n", k, v, srcSel)
+		switch {
+		case elem.Kind() == reflect.Struct && needsDeepCopy(elem):
+			fmt.Fprintf(buf, "\t\t\tvar %s %s\n", e, typeName(imports, elem))
+			emitStructClone(buf, e, v, elem, imports, depth+1)
+			fmt.Fprintf(buf, "\t\t\t%s[%s] = %s\n", dstSel, k, e)
+		case needsDeepCopy(elem):
+			fmt.Fprintf(buf, "\t\t\t%s := %s\n", e, v)
+			emitFieldCopy(buf, e, v, elem, imports, depth+1)
+			fmt.Fprintf(buf, "\t\t\t%s[%s] = %s\n", dstSel, k, e)
+		default:
+			fmt.Fprintf(buf, "\t\t\t%s[%s] = %s\n", dstSel, k, v)
+		}
+		buf.WriteString("\t\t}\n\t}\n")
+
+	case reflect.Struct:
+		emitStructClone(buf, dstSel, srcSel, t, imports, depth)
+	}
+}
+
+func writeCloneDispatcher(buf *bytes.Buffer, names []string) {
+	sort.Strings(names)
+
+	buf.WriteString("// Clone copies src into dst when both point to the same generated\n")
+	buf.WriteString("// model type, returning false otherwise.\n")
+	buf.WriteString("func Clone(dst, src any) bool {\n")
+	buf.WriteString("\tswitch s := src.(type) {\n")
+	for _, n := range names {
+		fmt.Fprintf(buf, "\tcase *%s:\n", n)
+		fmt.Fprintf(buf, "\t\td, ok := dst.(*%s)\n", n)
+		buf.WriteString("\t\tif !ok {\n\t\t\treturn false\n\t\t}\n")
+		buf.WriteString("\t\t*d = *s.Clone()\n")
+		buf.WriteString("\t\treturn true\n")
+	}
+	buf.WriteString("\t}\n\treturn false\n}\n")
+}
+
+// typeName renders t's name the way generated code inside package model
+// itself should spell it (sibling model types lose their "model."
+// qualifier since they're in the same package as the generated file),
+// and records an import for any other package t references so
+// writeImports can emit it.
+func typeName(imports map[string]string, t reflect.Type) string {
+	trackImports(imports, t)
+	return localTypeName(t)
+}
+
+func localTypeName(t reflect.Type) string {
+	return strings.ReplaceAll(t.String(), "model.", "")
+}
+
+// trackImports walks t (following Ptr/Slice/Map) recording the import
+// path of every non-model, non-builtin package it mentions, mapped to
+// the package identifier used in its type string.
+func trackImports(imports map[string]string, t reflect.Type) {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice:
+		trackImports(imports, t.Elem())
+	case reflect.Map:
+		trackImports(imports, t.Key())
+		trackImports(imports, t.Elem())
+	default:
+		if t.PkgPath() == "" || t.PkgPath() == modelPkgPath {
+			return
+		}
+		name := t.String()
+		if idx := strings.Index(name, "."); idx >= 0 {
+			name = name[:idx]
+		}
+		imports[t.PkgPath()] = name
+	}
+}
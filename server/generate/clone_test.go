@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"go/format"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+var selfAssignPattern = regexp.MustCompile(`\*(\w+) = \*(\w+)`)
+
+// cloneInner/cloneOuter reproduce the shape that broke emitFieldCopy:
+// a map whose value type is itself a pointer. Before temp names were
+// depth-qualified, the Ptr case's "v := new(Inner)" shadowed the
+// enclosing "for k, v := range" binding, so the very next line read
+// back from the freshly-zeroed pointer instead of the original
+// ("v := new(Inner); *v = *v").
+type cloneInner struct {
+	Name string
+}
+
+type cloneOuter struct {
+	M map[string]*cloneInner
+}
+
+func TestEmitFieldCopyMapOfPointerNoSelfAssignment(t *testing.T) {
+	var buf bytes.Buffer
+	imports := map[string]string{}
+	if err := writeCloneMethod(&buf, reflect.TypeOf(cloneOuter{}), imports); err != nil {
+		t.Fatalf("writeCloneMethod: %v", err)
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		t.Fatalf("generated Clone() is not valid Go: %v\n%s", err, buf.String())
+	}
+
+	for _, m := range selfAssignPattern.FindAllStringSubmatch(string(src), -1) {
+		if m[1] == m[2] {
+			t.Fatalf("generated Clone() self-assigns %q instead of copying from src:\n%s", m[0], src)
+		}
+	}
+}
@@ -45,4 +45,8 @@ func main() {
 		model.FrpClientCfg{},
 	)
 	g.Execute()
+
+	if err := genClone("./model"); err != nil {
+		panic(err)
+	}
 }
@@ -39,6 +39,10 @@ type Item struct {
 	SSHMatcher    ItemMatcher   `json:"sshMatcher"`
 	WhiteEnable   int           `json:"whiteEnable"`
 	WhiteList     []string      `json:"whiteList"`
+	// Removed is set on items pushed by Watch/WatchSSE to signal the
+	// forward no longer matches the subscription's filters (renamed,
+	// disabled, deleted); it is always false from Page itself.
+	Removed bool `json:"removed,omitempty"`
 }
 
 type ItemMatcher struct {
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+
+	"server/pkg/jwt"
+	"server/pkg/utils"
+	cache2 "server/repository/cache"
+)
+
+// watchCacheCheckInterval bounds how often Watch refreshes the live
+// fields (node/client online state, traffic counters) of items it has
+// already sent, straight from the cache — no DB query involved. This is
+// cheap enough to run often because it never touches the store.
+//
+// watchResyncInterval bounds how often Watch re-runs the full Page
+// query to pick up adds, removals and filter-changing edits (rename,
+// disable) that a cache-only refresh can't see. repository/cache
+// doesn't expose a mutation subscription, so this is a plain re-query;
+// it is kept far coarser than watchCacheCheckInterval specifically so
+// Watch doesn't turn into a heavier version of the polling it replaces.
+//
+// watchDebounce then coalesces bursts of change from either ticker
+// before anything is pushed to the subscriber. It must stay well under
+// watchCacheCheckInterval: a tunnel with ongoing traffic has its
+// InputBytes/OutputBytes differ on essentially every cacheTicker tick,
+// so queue() re-arms the debounce timer every tick, and a debounce
+// window equal to (or wider than) the check interval never gets a gap
+// to fire in — starving an active subscription of updates indefinitely.
+const (
+	watchCacheCheckInterval = 500 * time.Millisecond
+	watchResyncInterval     = 15 * time.Second
+	watchDebounce           = 150 * time.Millisecond
+)
+
+// Watch streams diffs of Page's result set instead of making callers
+// poll it. It performs one initial Page query, pushed immediately, then
+// keeps the live fields of already-sent items current from the cache on
+// watchCacheCheckInterval and falls back to a full Page re-query on the
+// much coarser watchResyncInterval to catch adds, removals and edits
+// that touch the filters themselves. Items that drop out of the result
+// set are pushed once more with Removed set rather than silently
+// dropped. Changes are coalesced within watchDebounce. The returned func
+// stops the watch and must be called exactly once by the caller (e.g.
+// when the HTTP client disconnects).
+func (service *service) Watch(claims jwt.Claims, req PageReq) (<-chan []Item, func()) {
+	out := make(chan []Item, 1)
+	stop := make(chan struct{})
+
+	go func() {
+		defer close(out)
+
+		last, _ := service.Page(claims, req)
+		out <- last
+		sent := indexByCode(last)
+
+		cacheTicker := time.NewTicker(watchCacheCheckInterval)
+		defer cacheTicker.Stop()
+
+		resyncTicker := time.NewTicker(watchResyncInterval)
+		defer resyncTicker.Stop()
+
+		debounce := time.NewTimer(watchDebounce)
+		if !debounce.Stop() {
+			<-debounce.C
+		}
+
+		pending := map[string]Item{}
+
+		queue := func(code string, item Item) {
+			pending[code] = item
+			debounce.Reset(watchDebounce)
+		}
+
+		for {
+			select {
+			case <-stop:
+				return
+
+			case <-cacheTicker.C:
+				for code, item := range sent {
+					if item.Removed {
+						continue
+					}
+					refreshed := refreshLiveFields(item)
+					if !reflect.DeepEqual(item, refreshed) {
+						sent[code] = refreshed
+						queue(code, refreshed)
+					}
+				}
+
+			case <-resyncTicker.C:
+				current, _ := service.Page(claims, req)
+				currentByCode := indexByCode(current)
+
+				for code, item := range currentByCode {
+					if prev, ok := sent[code]; !ok || !reflect.DeepEqual(prev, item) {
+						queue(code, item)
+					}
+				}
+				for code, prev := range sent {
+					if prev.Removed {
+						continue
+					}
+					if _, ok := currentByCode[code]; !ok {
+						tombstone := Item{Code: code, Removed: true}
+						currentByCode[code] = tombstone
+						queue(code, tombstone)
+					}
+				}
+				sent = currentByCode
+
+			case <-debounce.C:
+				if len(pending) == 0 {
+					continue
+				}
+				batch := make([]Item, 0, len(pending))
+				for _, item := range pending {
+					batch = append(batch, item)
+				}
+				pending = map[string]Item{}
+
+				select {
+				case out <- batch:
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+
+	return out, func() { close(stop) }
+}
+
+// refreshLiveFields returns item with its cache-backed fields (online
+// state, traffic counters) re-read from the live cache, the same lookups
+// Page itself uses. Everything else about item — including whether it
+// still matches the subscription's filters — is left untouched; that is
+// resyncTicker's job.
+func refreshLiveFields(item Item) Item {
+	item.Node.Online = utils.TrinaryOperation(cache2.GetNodeOnline(item.Node.Code), 1, 2)
+	item.Client.Online = utils.TrinaryOperation(cache2.GetClientOnline(item.Client.Code), 1, 2)
+
+	obsInfo := cache2.GetTunnelObsDateRange(cache2.MONTH_DATEONLY_LIST, item.Code)
+	item.InputBytes = obsInfo.InputBytes
+	item.OutputBytes = obsInfo.OutputBytes
+	return item
+}
+
+func indexByCode(items []Item) map[string]Item {
+	m := make(map[string]Item, len(items))
+	for _, item := range items {
+		m[item.Code] = item
+	}
+	return m
+}
+
+// WatchSSE writes Watch's diff stream to w as a text/event-stream
+// response until the client disconnects or r's context is cancelled.
+// Route wiring (auth middleware resolving claims, binding req from the
+// query string) is the caller's responsibility, same as Page.
+func (service *service) WatchSSE(w http.ResponseWriter, r *http.Request, claims jwt.Claims, req PageReq) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	ch, cancel := service.Watch(claims, req)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case items, ok := <-ch:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(items)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}